@@ -1,3 +1,7 @@
+// Command dagstore-kv-backend is a small example binary that exercises
+// pkg/kvindex: it loads every full.idx file from a directory, stores it
+// through the configured Store, then reads it back and checks the
+// round-trip matches.
 package main
 
 import (
@@ -5,76 +9,87 @@ import (
 	"bytes"
 	"context"
 	"flag"
-	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
-	"time"
 
 	"github.com/ipfs/go-cid"
-	"github.com/ipfs/go-datastore"
-	levelds "github.com/ipfs/go-ds-leveldb"
 	logging "github.com/ipfs/go-log/v2"
 	"github.com/ipld/go-car/v2/index"
-	"github.com/multiformats/go-multicodec"
-	"github.com/multiformats/go-multihash"
-	"github.com/syndtr/goleveldb/leveldb/opt"
-	ldbopts "github.com/syndtr/goleveldb/leveldb/opt"
+
+	"github.com/nonsense/dagstore-kv-backend/pkg/kvindex"
 )
 
+var log = logging.Logger("dagstore-kv-backend")
+
 var (
-	log      = logging.Logger("dagstore-kv-backend")
-	repopath string
-	gi       int
+	backend     string
+	repopath    string
+	indicesPath string
+	redisAddr   string
 )
 
 func init() {
 	logging.SetLogLevel("*", "info")
 
-	flag.StringVar(&repopath, "repopath", "", "path for repo")
+	flag.StringVar(&backend, "backend", "leveldb", "storage backend: leveldb, memory, or redis")
+	flag.StringVar(&repopath, "repopath", "", "path for the leveldb repo (defaults to a temp dir)")
+	flag.StringVar(&indicesPath, "indices", "", "path to a directory of .full.idx files")
+	flag.StringVar(&redisAddr, "redis-addr", "127.0.0.1:6379", "redis address, when -backend=redis")
 }
 
 func main() {
-	var err error
-	repopath, err := ioutil.TempDir("", "dagstore-kv-backend")
-	if err != nil {
-		panic(err)
+	flag.Parse()
+
+	if indicesPath == "" {
+		log.Fatal("-indices is required")
 	}
 
-	log.Infow("using repopath", "path", repopath)
-	db, err := levelDs(repopath, false)
-	if err != nil {
-		panic(err)
+	cfg := kvindex.Options{Kind: kvindex.Kind(backend)}
+	switch cfg.Kind {
+	case kvindex.KindLevelDB:
+		if repopath == "" {
+			var err error
+			repopath, err = ioutil.TempDir("", "dagstore-kv-backend")
+			if err != nil {
+				panic(err)
+			}
+		}
+		log.Infow("using repopath", "path", repopath)
+		cfg.LevelDB = kvindex.LevelDBConfig{Path: repopath}
+	case kvindex.KindRedis:
+		cfg.Redis = kvindex.RedisConfig{Addr: redisAddr}
 	}
 
 	ctx := context.Background()
 
-	// prepare db
-	db.SetNextCursor(ctx, 100)
+	store, err := kvindex.Open(ctx, cfg)
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = store.Close(ctx) }()
 
-	indicesPath := "/Users/nonsense/dagstore-indices/"
 	log.Infow("using indicesPath", "path", indicesPath)
 
 	indices := getAllIndices(indicesPath)
 
-	for fcid, filepath := range indices {
+	for fcid, path := range indices {
 		pieceCid, err := cid.Parse(fcid)
 		if err != nil {
 			panic(err)
 		}
 
-		subject, err := loadIndex(filepath)
+		subject, err := loadIndex(path)
 		if err != nil {
 			panic(err)
 		}
 
-		err = storeIndex(ctx, pieceCid, subject, db)
-		if err != nil {
+		if err := store.PutIndex(ctx, pieceCid, subject); err != nil {
 			panic(err)
 		}
 
-		subjectDb, err := loadIndexFromDb(ctx, db, pieceCid)
+		subjectDb, err := loadIndexFromStore(ctx, store, pieceCid)
 		if err != nil {
 			panic(err)
 		}
@@ -88,31 +103,7 @@ func main() {
 		}
 	}
 
-	log.Infow("all good")
-
-	time.Sleep(3 * time.Second)
-
-	indicesSize, _ := DirSize(indicesPath)
-	ldbSize, _ := DirSize(repopath)
-
-	log.Infow("cursor overhead", "count", gi, "overhead", ByteCountSI(int64(gi*8)))
-	log.Infow("size indices", "size", ByteCountSI(indicesSize))
-	log.Infow("size leveldb", "size", ByteCountSI(ldbSize))
-}
-
-func levelDs(path string, readonly bool) (*DB, error) {
-	ldb, err := levelds.NewDatastore(path, &levelds.Options{
-		Compression:         ldbopts.SnappyCompression,
-		NoSync:              true,
-		Strict:              ldbopts.StrictAll,
-		ReadOnly:            readonly,
-		CompactionTableSize: 4 * opt.MiB,
-	})
-	if err != nil {
-		return nil, err
-	}
-
-	return &DB{ldb}, nil
+	log.Infow("all good", "pieces", len(indices))
 }
 
 func getAllIndices(path string) map[string]string {
@@ -126,149 +117,52 @@ func getAllIndices(path string) map[string]string {
 		name := f.Name()
 
 		if strings.Contains(name, "full.idx") {
-			filepath := path + name
+			fpath := filepath.Join(path, name)
 			name = strings.ReplaceAll(name, ".full.idx", "")
 
-			result[name] = filepath
+			result[name] = fpath
 		}
 	}
 
 	return result
 }
 
-func storeIndex(ctx context.Context, pieceCid cid.Cid, subject index.Index, db *DB) error {
-	defer func(now time.Time) {
-		log.Debugw("storeindex", "took", fmt.Sprintf("%s", time.Since(now)))
-	}(time.Now())
-
-	// get and set next cursor (handle synchronization, maybe with CAS)
-	cursor, keyCursorPrefix, err := db.NextCursor(ctx)
-	if err != nil {
-		return err
-	}
-
-	err = db.SetNextCursor(ctx, cursor+1)
-	if err != nil {
-		return err
-	}
-
-	// put pieceCid in pieceCid->cursor table
-	err = db.SetPieceCidToCursor(ctx, pieceCid, cursor)
-	if err != nil {
-		return err
-	}
-
-	// process index and store entries
-	switch idx := subject.(type) {
-	case index.IterableIndex:
-		i := 0
-		err := idx.ForEach(func(m multihash.Multihash, offset uint64) error {
-			i++
-			gi++
-
-			err := db.AddOffset(ctx, keyCursorPrefix, m, offset)
-			if err != nil {
-				return err
-			}
-
-			return nil
-		})
-		if err != nil {
-			return err
-		}
-
-		log.Debugf(fmt.Sprintf("processed %d index entries for piece cid %s", i, pieceCid.String()))
-	default:
-		panic(fmt.Sprintf("wanted %v but got %v\n", multicodec.CarMultihashIndexSorted, idx.Codec()))
-	}
-
-	err = db.Sync(ctx, datastore.NewKey(keyCursorPrefix))
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
 func loadIndex(path string) (index.Index, error) {
-	defer func(now time.Time) {
-		log.Debugw("loadindex", "took", fmt.Sprintf("%s", time.Since(now)))
-	}(time.Now())
-
 	idxf, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
 	defer idxf.Close()
 
-	subject, err := index.ReadFrom(idxf)
-	if err != nil {
-		return nil, err
-	}
-
-	return subject, nil
+	return index.ReadFrom(idxf)
 }
 
-func loadIndexFromDb(ctx context.Context, db *DB, pieceCid cid.Cid) (index.Index, error) {
-	cursor, err := db.GetPieceCidToCursor(ctx, pieceCid)
-	if err != nil {
-		return nil, err
-	}
-
-	records, err := db.AllRecords(ctx, cursor)
+func loadIndexFromStore(ctx context.Context, store kvindex.Store, pieceCid cid.Cid) (index.Index, error) {
+	records, err := store.GetRecords(ctx, pieceCid)
 	if err != nil {
 		return nil, err
 	}
 
 	mis := make(index.MultihashIndexSorted)
-	err = mis.Load(records)
-	if err != nil {
+	if err := mis.Load(records); err != nil {
 		return nil, err
 	}
 
 	return &mis, nil
 }
 
-func ByteCountSI(b int64) string {
-	const unit = 1000
-	if b < unit {
-		return fmt.Sprintf("%d B", b)
-	}
-	div, exp := int64(unit), 0
-	for n := b / unit; n >= unit; n /= unit {
-		div *= unit
-		exp++
-	}
-	return fmt.Sprintf("%.1f %cB",
-		float64(b)/float64(div), "kMGTPE"[exp])
-}
-
-func DirSize(path string) (int64, error) {
-	var size int64
-	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if !info.IsDir() {
-			size += info.Size()
-		}
-		return err
-	})
-	return size, err
-}
-
 func compareIndices(subject, subjectDb index.Index) (bool, error) {
 	var b bytes.Buffer
 	w := bufio.NewWriter(&b)
-
-	subject.Marshal(w)
+	if _, err := subject.Marshal(w); err != nil {
+		return false, err
+	}
 
 	var b2 bytes.Buffer
 	w2 := bufio.NewWriter(&b2)
+	if _, err := subjectDb.Marshal(w2); err != nil {
+		return false, err
+	}
 
-	subjectDb.Marshal(w2)
-
-	res := bytes.Compare(b.Bytes(), b2.Bytes())
-
-	return res == 0, nil
+	return bytes.Equal(b.Bytes(), b2.Bytes()), nil
 }
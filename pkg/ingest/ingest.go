@@ -0,0 +1,361 @@
+// Package ingest lets callers hand off "index this piece" work without
+// blocking on the underlying kvindex.Store's write latency. Pending jobs
+// are persisted so they survive a restart, and a failing job is retried
+// with exponential backoff before being moved to a dead-letter queue.
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/ipld/go-car/v2/index"
+
+	"github.com/nonsense/dagstore-kv-backend/pkg/kvindex"
+)
+
+const (
+	// queuePrefix namespaces pending jobs: "queue/{ts}-{pieceCid}".
+	queuePrefix = "queue/"
+	// deadQueuePrefix namespaces jobs that exhausted their retries.
+	deadQueuePrefix = "queue-dead/"
+
+	defaultWorkers     = 1
+	defaultMaxAttempts = 5
+	baseBackoff        = time.Second
+)
+
+// job is the persisted unit of work: index the CAR index file at IndexPath
+// under PieceCid.
+type job struct {
+	Ts        int64  `json:"ts"`
+	PieceCid  string `json:"pieceCid"`
+	IndexPath string `json:"indexPath"`
+	Attempt   int    `json:"attempt"`
+}
+
+func (j job) key(prefix string) datastore.Key {
+	return datastore.NewKey(fmt.Sprintf("%s%d-%s", prefix, j.Ts, j.PieceCid))
+}
+
+// terminalResult records the outcome notify delivered for a pieceCid, so a
+// Wait call that arrives after the job already finished can return it
+// immediately instead of blocking forever.
+type terminalResult struct {
+	err error
+}
+
+// Options configures an Ingester.
+type Options struct {
+	// Store is where successfully loaded indices are written.
+	Store kvindex.Store
+	// Queue is the datastore backing persisted pending jobs, so they survive
+	// a restart. Typically a dedicated LevelDB datastore.
+	Queue datastore.Batching
+	// Workers is how many jobs are processed concurrently. Defaults to 1.
+	Workers int
+	// MaxAttempts is how many times a job is attempted in total, counting
+	// its first try, before it is moved to the dead queue. Defaults to 5.
+	MaxAttempts int
+}
+
+// Stats summarizes an Ingester's queue state.
+type Stats struct {
+	Pending  int
+	InFlight int
+	Failed   int
+}
+
+// Ingester is the async ingestion queue described above.
+type Ingester struct {
+	store       kvindex.Store
+	queue       datastore.Batching
+	maxAttempts int
+
+	jobs chan job
+
+	mu       sync.Mutex
+	pending  int
+	inFlight int
+	failed   int
+	waiters  map[string][]chan error
+	results  map[string]terminalResult
+
+	wg       sync.WaitGroup
+	stopOnce sync.Once
+	stopCh   chan struct{}
+}
+
+// New creates an Ingester and starts its worker pool. Call Recover once, at
+// startup, to re-enqueue any jobs a previous run left pending.
+func New(opts Options) *Ingester {
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+	maxAttempts := opts.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+
+	g := &Ingester{
+		store:       opts.Store,
+		queue:       opts.Queue,
+		maxAttempts: maxAttempts,
+		jobs:        make(chan job, 64),
+		waiters:     make(map[string][]chan error),
+		results:     make(map[string]terminalResult),
+		stopCh:      make(chan struct{}),
+	}
+
+	for i := 0; i < workers; i++ {
+		g.wg.Add(1)
+		go g.worker()
+	}
+
+	return g
+}
+
+// Recover re-enqueues every job persisted under the pending queue prefix,
+// picking up work a crash or restart left behind.
+func (g *Ingester) Recover(ctx context.Context) error {
+	var q query.Query
+	q.Prefix = queuePrefix
+
+	results, err := g.queue.Query(ctx, q)
+	if err != nil {
+		return err
+	}
+
+	for {
+		r, ok := results.NextSync()
+		if !ok {
+			break
+		}
+
+		var j job
+		if err := json.Unmarshal(r.Value, &j); err != nil {
+			continue
+		}
+
+		g.dispatch(j)
+	}
+
+	return nil
+}
+
+// Enqueue persists a job to index pieceCid from the CAR index file at
+// indexPath and returns once that's been durably recorded - not once the
+// piece has actually been indexed. Use Wait to block for completion.
+func (g *Ingester) Enqueue(ctx context.Context, pieceCid cid.Cid, indexPath string) error {
+	j := job{
+		Ts:        time.Now().UnixNano(),
+		PieceCid:  pieceCid.String(),
+		IndexPath: indexPath,
+	}
+
+	b, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+
+	if err := g.queue.Put(ctx, j.key(queuePrefix), b); err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	delete(g.results, j.PieceCid)
+	g.mu.Unlock()
+
+	g.dispatch(j)
+	return nil
+}
+
+// dispatch hands j to a worker, unless Shutdown has already been called.
+func (g *Ingester) dispatch(j job) {
+	g.mu.Lock()
+	g.pending++
+	g.mu.Unlock()
+
+	select {
+	case g.jobs <- j:
+	case <-g.stopCh:
+	}
+}
+
+// Wait blocks until pieceCid's job reaches a terminal state (indexed, or
+// moved to the dead queue), returning the error from the final attempt. If
+// the job already reached a terminal state before Wait was called, it
+// returns that result immediately.
+func (g *Ingester) Wait(ctx context.Context, pieceCid cid.Cid) error {
+	key := pieceCid.String()
+
+	g.mu.Lock()
+	if res, ok := g.results[key]; ok {
+		g.mu.Unlock()
+		return res.err
+	}
+
+	ch := make(chan error, 1)
+	g.waiters[key] = append(g.waiters[key], ch)
+	g.mu.Unlock()
+
+	select {
+	case err := <-ch:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stats reports the current queue state.
+func (g *Ingester) Stats() Stats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return Stats{
+		Pending:  g.pending,
+		InFlight: g.inFlight,
+		Failed:   g.failed,
+	}
+}
+
+// Shutdown stops handing out new work and waits for in-flight jobs to
+// finish, or for ctx to be done, whichever happens first.
+func (g *Ingester) Shutdown(ctx context.Context) error {
+	g.stopOnce.Do(func() { close(g.stopCh) })
+
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (g *Ingester) worker() {
+	defer g.wg.Done()
+
+	for {
+		select {
+		case j := <-g.jobs:
+			select {
+			case <-g.stopCh:
+				// Shutdown was called; j stays persisted for Recover.
+				return
+			default:
+			}
+
+			g.mu.Lock()
+			g.pending--
+			g.inFlight++
+			g.mu.Unlock()
+
+			terminal, err := g.attempt(context.Background(), j)
+
+			g.mu.Lock()
+			g.inFlight--
+			g.mu.Unlock()
+
+			if terminal {
+				g.notify(j.PieceCid, err)
+			}
+		case <-g.stopCh:
+			return
+		}
+	}
+}
+
+// attempt loads and stores j's index once. On success, or once j has used
+// its last retry, it reports terminal=true. Otherwise it persists j with an
+// incremented attempt count and schedules a backed-off retry.
+func (g *Ingester) attempt(ctx context.Context, j job) (terminal bool, err error) {
+	oldKey := j.key(queuePrefix)
+
+	runErr := g.run(ctx, j)
+	if runErr == nil {
+		return true, g.queue.Delete(ctx, oldKey)
+	}
+
+	if err := g.queue.Delete(ctx, oldKey); err != nil {
+		return true, err
+	}
+
+	j.Attempt++
+	if j.Attempt >= g.maxAttempts {
+		g.mu.Lock()
+		g.failed++
+		g.mu.Unlock()
+
+		b, merr := json.Marshal(j)
+		if merr != nil {
+			return true, merr
+		}
+		if err := g.queue.Put(ctx, j.key(deadQueuePrefix), b); err != nil {
+			return true, err
+		}
+		return true, runErr
+	}
+
+	j.Ts = time.Now().UnixNano()
+	b, merr := json.Marshal(j)
+	if merr != nil {
+		return true, merr
+	}
+	if err := g.queue.Put(ctx, j.key(queuePrefix), b); err != nil {
+		return true, err
+	}
+
+	backoff := baseBackoff << uint(j.Attempt-1)
+	time.AfterFunc(backoff, func() { g.dispatch(j) })
+
+	return false, nil
+}
+
+func (g *Ingester) run(ctx context.Context, j job) error {
+	pieceCid, err := cid.Parse(j.PieceCid)
+	if err != nil {
+		return err
+	}
+
+	idx, err := loadIndex(j.IndexPath)
+	if err != nil {
+		return err
+	}
+
+	return g.store.PutIndex(ctx, pieceCid, idx)
+}
+
+func (g *Ingester) notify(pieceCid string, err error) {
+	g.mu.Lock()
+	g.results[pieceCid] = terminalResult{err: err}
+	waiters := g.waiters[pieceCid]
+	delete(g.waiters, pieceCid)
+	g.mu.Unlock()
+
+	for _, ch := range waiters {
+		ch <- err
+	}
+}
+
+func loadIndex(path string) (index.Index, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return index.ReadFrom(f)
+}
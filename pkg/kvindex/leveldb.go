@@ -0,0 +1,567 @@
+package kvindex
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"github.com/RoaringBitmap/roaring"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	levelds "github.com/ipfs/go-ds-leveldb"
+	"github.com/ipld/go-car/v2/index"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	ldbopts "github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+const (
+	// keyNextCursor is the datastore key holding the next free cursor.
+	keyNextCursor = "next-cursor"
+	// prefixPieceCidToCursor is the key prefix for the pieceCid->cursor table:
+	// "p2c/{cid}". It's a fixed ASCII tag rather than, say, a small integer,
+	// so it can never collide with the raw varint cursor bytes that prefix
+	// every cursor's own offset entries (cursors are handed out starting at
+	// 0, so a numeric tag would eventually equal a real cursor value).
+	prefixPieceCidToCursor = "p2c"
+	// prefixCursorToPieceCid is the key prefix for the cursor->pieceCid
+	// table, the inverse of prefixPieceCidToCursor: "c2p/{cursor}". It lets
+	// PiecesContaining turn the piece ids stored in a mh2p/ bitmap back into
+	// pieceCIDs.
+	prefixCursorToPieceCid = "c2p/"
+	// prefixMhToPieces is the key prefix for the multihash->piece-ids reverse
+	// index: "mh2p/{mh}" holds a roaring.Bitmap of every piece's cursor that
+	// contains that multihash.
+	prefixMhToPieces = "mh2p/"
+
+	cursorSize = binary.MaxVarintLen64
+	cutsize    = cursorSize + 2
+
+	// cursorLeaseSize is how many cursors ReserveCursors leases from disk at
+	// a time. Persisting the high-water mark once per lease, instead of once
+	// per reservation, means a burst of concurrent ingests only pays for one
+	// disk write per cursorLeaseSize reservations; a crash before the leased
+	// cursors are all handed out just strands the remainder of the lease.
+	cursorLeaseSize uint64 = 64
+)
+
+// LevelDBConfig configures the on-disk LevelDB backend.
+type LevelDBConfig struct {
+	// Path is the directory the LevelDB datastore is opened from.
+	Path string
+	// ReadOnly opens the datastore without acquiring the write lock.
+	ReadOnly bool
+	// CompactionTableSize overrides LevelDB's default SSTable size, in bytes.
+	// Zero keeps LevelDB's default.
+	CompactionTableSize int
+	// NoSync skips fsync on writes, trading durability for throughput.
+	NoSync bool
+}
+
+// LevelDBStore is the on-disk Store backend, built on go-ds-leveldb. It keeps
+// the same cursor-based layout the original package-main implementation
+// used: every piece is assigned a cursor, and its multihash->offset entries
+// are stored under the key prefix "{cursor}/".
+type LevelDBStore struct {
+	ds datastore.Batching
+
+	dskeyNextCursor datastore.Key
+
+	cursorMu   sync.Mutex
+	nextCursor uint64 // next cursor to hand out; guarded by cursorMu
+	leasedTo   uint64 // high-water mark already persisted to disk; guarded by cursorMu
+
+	// bitmapMu serializes the mh2p/ bitmap read-modify-write in
+	// updateMhBitmap. The pieceCid<->cursor and offset writes in PutIndex and
+	// RemovePiece are already isolated from each other by their own per-call
+	// batch, but two calls that share a multihash both read the same
+	// committed bitmap before either commits, so without this mutex whichever
+	// batch.Commit lands last would silently discard the other's update.
+	bitmapMu sync.Mutex
+}
+
+var _ Store = (*LevelDBStore)(nil)
+
+// NewLevelDBStore opens (creating if necessary) a LevelDB-backed Store at
+// cfg.Path, recovering the cursor high-water mark persisted by a previous
+// run.
+func NewLevelDBStore(ctx context.Context, cfg LevelDBConfig) (*LevelDBStore, error) {
+	opts := &levelds.Options{
+		Compression: ldbopts.SnappyCompression,
+		NoSync:      cfg.NoSync,
+		Strict:      ldbopts.StrictAll,
+		ReadOnly:    cfg.ReadOnly,
+	}
+	if cfg.CompactionTableSize > 0 {
+		opts.CompactionTableSize = cfg.CompactionTableSize
+	}
+
+	ds, err := levelds.NewDatastore(cfg.Path, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	dskeyNextCursor := datastore.NewKey(keyNextCursor)
+
+	s := &LevelDBStore{
+		ds:              ds,
+		dskeyNextCursor: dskeyNextCursor,
+	}
+
+	leased, err := ds.Get(ctx, dskeyNextCursor)
+	if err != nil && err != datastore.ErrNotFound {
+		return nil, err
+	}
+	if err == nil {
+		highWaterMark, _ := binary.Uvarint(leased)
+		s.nextCursor, s.leasedTo = highWaterMark, highWaterMark
+	}
+
+	return s, nil
+}
+
+// ReserveCursors atomically hands out n consecutive cursor ids, returning
+// the first one. The high-water mark is persisted to disk in leases of
+// cursorLeaseSize rather than on every call, so a crash can strand unused
+// cursors but two concurrent callers can never be handed the same one.
+func (s *LevelDBStore) ReserveCursors(ctx context.Context, n uint64) (uint64, error) {
+	s.cursorMu.Lock()
+	defer s.cursorMu.Unlock()
+
+	start := s.nextCursor
+	end := start + n
+
+	if end > s.leasedTo {
+		lease := end
+		if start+cursorLeaseSize > lease {
+			lease = start + cursorLeaseSize
+		}
+
+		buf := make([]byte, cursorSize)
+		binary.PutUvarint(buf, lease)
+		if err := s.ds.Put(ctx, s.dskeyNextCursor, buf); err != nil {
+			return 0, err
+		}
+
+		s.leasedTo = lease
+	}
+
+	s.nextCursor = end
+	return start, nil
+}
+
+func (s *LevelDBStore) pieceCidToCursorKey(pieceCid cid.Cid) datastore.Key {
+	return datastore.NewKey(fmt.Sprintf("%s/%s", prefixPieceCidToCursor, pieceCid.String()))
+}
+
+func (s *LevelDBStore) getPieceCidToCursor(ctx context.Context, pieceCid cid.Cid) (uint64, error) {
+	b, err := s.ds.Get(ctx, s.pieceCidToCursorKey(pieceCid))
+	if err != nil {
+		return 0, err
+	}
+
+	cursor, _ := binary.Uvarint(b)
+	return cursor, nil
+}
+
+func (s *LevelDBStore) cursorToPieceCidKey(cursor uint64) datastore.Key {
+	buf := make([]byte, cursorSize)
+	binary.PutUvarint(buf, cursor)
+	return datastore.NewKey(fmt.Sprintf("%s%s", prefixCursorToPieceCid, string(buf)))
+}
+
+func (s *LevelDBStore) getCursorToPieceCid(ctx context.Context, cursor uint64) (cid.Cid, error) {
+	b, err := s.ds.Get(ctx, s.cursorToPieceCidKey(cursor))
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	return cid.Parse(string(b))
+}
+
+func mhToPiecesKey(m multihash.Multihash) datastore.Key {
+	return datastore.NewKey(fmt.Sprintf("%s%s", prefixMhToPieces, m.String()))
+}
+
+// piecesContainingBitmap loads the roaring.Bitmap of piece ids stored for m,
+// returning an empty bitmap if none has been written yet.
+func (s *LevelDBStore) piecesContainingBitmap(ctx context.Context, m multihash.Multihash) (*roaring.Bitmap, error) {
+	bm := roaring.New()
+
+	b, err := s.ds.Get(ctx, mhToPiecesKey(m))
+	if err == datastore.ErrNotFound {
+		return bm, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := bm.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+
+	return bm, nil
+}
+
+// updateMhBitmap applies mutate to the mh2p/ bitmap stored for m and writes
+// the result straight to s.ds, holding bitmapMu for the whole read-modify-
+// write. PutIndex and RemovePiece each use their own batch to stay atomic,
+// but piecesContainingBitmap reads committed state rather than a caller's
+// in-flight batch, so atomicity alone doesn't stop two concurrent callers
+// sharing a multihash from both loading the same bitmap and one clobbering
+// the other's update; bitmapMu serializes them instead. Writing straight to
+// s.ds rather than through the caller's batch means a crash between this
+// write and the caller's batch.Commit can leave the bitmap updated without
+// the piece it describes yet being visible, or vice versa, which is an
+// acceptable tradeoff since mh2p/ is a derived, best-effort reverse index.
+func (s *LevelDBStore) updateMhBitmap(ctx context.Context, m multihash.Multihash, mutate func(*roaring.Bitmap)) error {
+	s.bitmapMu.Lock()
+	defer s.bitmapMu.Unlock()
+
+	bm, err := s.piecesContainingBitmap(ctx, m)
+	if err != nil {
+		return err
+	}
+
+	mutate(bm)
+
+	key := mhToPiecesKey(m)
+	if bm.IsEmpty() {
+		return s.ds.Delete(ctx, key)
+	}
+
+	bmBytes, err := bm.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	return s.ds.Put(ctx, key, bmBytes)
+}
+
+// PiecesContaining returns every pieceCid whose index contains m.
+func (s *LevelDBStore) PiecesContaining(ctx context.Context, m multihash.Multihash) ([]cid.Cid, error) {
+	bm, err := s.piecesContainingBitmap(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+
+	pieceCids := make([]cid.Cid, 0, bm.GetCardinality())
+	it := bm.Iterator()
+	for it.HasNext() {
+		pieceCid, err := s.getCursorToPieceCid(ctx, uint64(it.Next()))
+		if err != nil {
+			return nil, err
+		}
+		pieceCids = append(pieceCids, pieceCid)
+	}
+
+	return pieceCids, nil
+}
+
+// PutIndex implements Store.
+//
+// If pieceCid already has an index stored, PutIndex removes it first: a
+// fresh cursor is always allocated, so without this the old cursor's offset
+// rows would leak and, worse, any multihash present in the old index but not
+// the new one would keep a stale mh2p/ bitmap entry pointing at the
+// now-orphaned cursor, making PiecesContaining report pieces that no longer
+// contain that multihash.
+//
+// The pieceCid<->cursor mappings and every multihash's offset entry are
+// accumulated into a single datastore.Batch and committed at the end, so a
+// crash mid-ingest leaves no partially-written piece visible to readers. The
+// mh2p/ bitmap updates go through updateMhBitmap instead, which serializes
+// concurrent callers sharing a multihash rather than joining the batch; see
+// its doc comment.
+func (s *LevelDBStore) PutIndex(ctx context.Context, pieceCid cid.Cid, idx index.Index) error {
+	if has, err := s.HasPiece(ctx, pieceCid); err != nil {
+		return err
+	} else if has {
+		if err := s.RemovePiece(ctx, pieceCid); err != nil {
+			return err
+		}
+	}
+
+	cursor, err := s.ReserveCursors(ctx, 1)
+	if err != nil {
+		return err
+	}
+	keyCursorPrefix := cursorPrefix(cursor)
+
+	batch, err := s.ds.Batch(ctx)
+	if err != nil {
+		return err
+	}
+
+	cursorBuf := make([]byte, cursorSize)
+	binary.PutUvarint(cursorBuf, cursor)
+	if err := batch.Put(ctx, s.pieceCidToCursorKey(pieceCid), cursorBuf); err != nil {
+		return err
+	}
+
+	if err := batch.Put(ctx, s.cursorToPieceCidKey(cursor), []byte(pieceCid.String())); err != nil {
+		return err
+	}
+
+	switch idx := idx.(type) {
+	case index.IterableIndex:
+		err := idx.ForEach(func(m multihash.Multihash, offset uint64) error {
+			key := datastore.NewKey(fmt.Sprintf("%s%s", keyCursorPrefix, m.String()))
+
+			value := make([]byte, cursorSize)
+			binary.PutUvarint(value, offset)
+
+			if err := batch.Put(ctx, key, value); err != nil {
+				return err
+			}
+
+			return s.updateMhBitmap(ctx, m, func(bm *roaring.Bitmap) {
+				bm.Add(uint32(cursor))
+			})
+		})
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("kvindex: wanted %v but got %v", multicodec.CarMultihashIndexSorted, idx.Codec())
+	}
+
+	if err := batch.Commit(ctx); err != nil {
+		return err
+	}
+
+	return s.ds.Sync(ctx, datastore.NewKey(keyCursorPrefix))
+}
+
+// GetOffset implements Store.
+func (s *LevelDBStore) GetOffset(ctx context.Context, pieceCid cid.Cid, mh multihash.Multihash) (uint64, error) {
+	cursor, err := s.getPieceCidToCursor(ctx, pieceCid)
+	if err != nil {
+		return 0, err
+	}
+
+	buf := make([]byte, cursorSize)
+	binary.PutUvarint(buf, cursor)
+	key := datastore.NewKey(fmt.Sprintf("%s/%s", string(buf), mh.String()))
+
+	b, err := s.ds.Get(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+
+	offset, _ := binary.Uvarint(b)
+	return offset, nil
+}
+
+// GetRecords implements Store.
+func (s *LevelDBStore) GetRecords(ctx context.Context, pieceCid cid.Cid) ([]index.Record, error) {
+	cursor, err := s.getPieceCidToCursor(ctx, pieceCid)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []index.Record
+
+	buf := make([]byte, cursorSize)
+	binary.PutUvarint(buf, cursor)
+
+	var q query.Query
+	q.Prefix = string(buf)
+
+	results, err := s.ds.Query(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		r, ok := results.NextSync()
+		if !ok {
+			break
+		}
+
+		m, err := multihash.FromHexString(r.Key[cutsize:])
+		if err != nil {
+			return nil, err
+		}
+
+		offset, _ := binary.Uvarint(r.Value)
+
+		records = append(records, index.Record{
+			Cid:    cid.NewCidV1(cid.Raw, m),
+			Offset: offset,
+		})
+	}
+
+	return records, nil
+}
+
+// HasPiece implements Store.
+func (s *LevelDBStore) HasPiece(ctx context.Context, pieceCid cid.Cid) (bool, error) {
+	return s.ds.Has(ctx, s.pieceCidToCursorKey(pieceCid))
+}
+
+// cursorPrefix returns the query prefix under which a cursor's offset
+// entries are stored, matching the prefix PutIndex writes them with.
+func cursorPrefix(cursor uint64) string {
+	buf := make([]byte, cursorSize)
+	binary.PutUvarint(buf, cursor)
+	return string(buf) + "/"
+}
+
+// RemovePiece implements Store.
+//
+// It resolves pieceCid's cursor, deletes every offset entry stored under
+// that cursor's prefix and finally the pieceCid<->cursor mappings, all via a
+// single batch so a crash mid-removal can't leave a half-deleted piece. The
+// reverse mh2p/ bitmap entries are dropped through updateMhBitmap, outside
+// that batch; see its doc comment.
+func (s *LevelDBStore) RemovePiece(ctx context.Context, pieceCid cid.Cid) error {
+	cursor, err := s.getPieceCidToCursor(ctx, pieceCid)
+	if err != nil {
+		return err
+	}
+
+	records, err := s.GetRecords(ctx, pieceCid)
+	if err != nil {
+		return err
+	}
+
+	batch, err := s.ds.Batch(ctx)
+	if err != nil {
+		return err
+	}
+
+	var q query.Query
+	q.Prefix = cursorPrefix(cursor)
+	q.KeysOnly = true
+
+	results, err := s.ds.Query(ctx, q)
+	if err != nil {
+		return err
+	}
+	for {
+		r, ok := results.NextSync()
+		if !ok {
+			break
+		}
+		if err := batch.Delete(ctx, datastore.NewKey(r.Key)); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range records {
+		m := r.Hash()
+		if err := s.updateMhBitmap(ctx, m, func(bm *roaring.Bitmap) {
+			bm.Remove(uint32(cursor))
+		}); err != nil {
+			return err
+		}
+	}
+
+	if err := batch.Delete(ctx, s.cursorToPieceCidKey(cursor)); err != nil {
+		return err
+	}
+	if err := batch.Delete(ctx, s.pieceCidToCursorKey(pieceCid)); err != nil {
+		return err
+	}
+
+	return batch.Commit(ctx)
+}
+
+// GC scans the pieceCid->cursor table and prunes cursor ranges that were
+// never linked to a pieceCid: rows written by a storeIndex that was
+// interrupted (e.g. by a crash) between reserving a cursor and recording the
+// pieceCid->cursor mapping for it, and the mh2p/ bitmap bits that reference
+// those pruned cursors. PutIndex now writes both the pieceCid->cursor
+// mapping and the offset entries in a single batch, so this codebase can no
+// longer produce orphans itself; GC remains for data written by older,
+// pre-atomic versions.
+func (s *LevelDBStore) GC(ctx context.Context) error {
+	// The prefix query below depends on every pieceCid->cursor key actually
+	// starting with "prefixPieceCidToCursor/" - true now that the prefix is
+	// a fixed ASCII tag, but it would silently break again if that prefix
+	// were changed back to something built from the same varint encoding as
+	// cursor values, since a cursor is eventually assigned every small
+	// integer and would then collide with the tag.
+	var q query.Query
+	q.Prefix = prefixPieceCidToCursor
+
+	results, err := s.ds.Query(ctx, q)
+	if err != nil {
+		return err
+	}
+
+	live := make(map[uint64]bool)
+	for {
+		r, ok := results.NextSync()
+		if !ok {
+			break
+		}
+		cursor, _ := binary.Uvarint(r.Value)
+		live[cursor] = true
+	}
+
+	next, err := s.ReserveCursors(ctx, 0)
+	if err != nil {
+		return err
+	}
+
+	batch, err := s.ds.Batch(ctx)
+	if err != nil {
+		return err
+	}
+
+	var pruned int
+	for cursor := uint64(0); cursor < next; cursor++ {
+		if live[cursor] {
+			continue
+		}
+
+		var cq query.Query
+		cq.Prefix = cursorPrefix(cursor)
+		cq.KeysOnly = true
+
+		orphaned, err := s.ds.Query(ctx, cq)
+		if err != nil {
+			return err
+		}
+
+		empty := true
+		for {
+			r, ok := orphaned.NextSync()
+			if !ok {
+				break
+			}
+			empty = false
+			if err := batch.Delete(ctx, datastore.NewKey(r.Key)); err != nil {
+				return err
+			}
+
+			m, err := multihash.FromHexString(r.Key[cutsize:])
+			if err != nil {
+				return err
+			}
+			if err := s.updateMhBitmap(ctx, m, func(bm *roaring.Bitmap) {
+				bm.Remove(uint32(cursor))
+			}); err != nil {
+				return err
+			}
+		}
+		if !empty {
+			pruned++
+		}
+	}
+
+	if pruned == 0 {
+		return nil
+	}
+
+	return batch.Commit(ctx)
+}
+
+// Close implements Store.
+func (s *LevelDBStore) Close(ctx context.Context) error {
+	return s.ds.Close()
+}
@@ -0,0 +1,146 @@
+package kvindex
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/RoaringBitmap/roaring"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipld/go-car/v2/index"
+	"github.com/multiformats/go-multihash"
+)
+
+// benchIndexSize approximates the number of multihashes in a real full.idx
+// for a ~1GiB piece. No full.idx fixture ships with this repo, so the
+// benchmarks build a synthetic index of this size instead.
+const benchIndexSize = 50_000
+
+func newBenchIndex(b *testing.B) index.Index {
+	b.Helper()
+
+	records := make([]index.Record, benchIndexSize)
+	for i := range records {
+		mh, err := multihash.Sum([]byte(fmt.Sprintf("bench-%d", i)), multihash.SHA2_256, -1)
+		if err != nil {
+			b.Fatal(err)
+		}
+		records[i] = index.Record{Cid: cid.NewCidV1(cid.Raw, mh), Offset: uint64(i)}
+	}
+
+	idx := index.NewMultihashSorted()
+	if err := idx.Load(records); err != nil {
+		b.Fatal(err)
+	}
+
+	return idx
+}
+
+// BenchmarkPutIndex measures the current PutIndex, which accumulates the
+// pieceCid<->cursor mappings and every offset entry into a single
+// datastore.Batch committed once at the end.
+func BenchmarkPutIndex(b *testing.B) {
+	ctx := context.Background()
+	idx := newBenchIndex(b)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		store, err := NewLevelDBStore(ctx, LevelDBConfig{Path: b.TempDir()})
+		if err != nil {
+			b.Fatal(err)
+		}
+		pieceMh, err := multihash.Sum([]byte(fmt.Sprintf("piece-%d", i)), multihash.SHA2_256, -1)
+		if err != nil {
+			b.Fatal(err)
+		}
+		pieceCid := cid.NewCidV1(cid.Raw, pieceMh)
+		b.StartTimer()
+
+		if err := store.PutIndex(ctx, pieceCid, idx); err != nil {
+			b.Fatal(err)
+		}
+
+		b.StopTimer()
+		_ = store.Close(ctx)
+		b.StartTimer()
+	}
+}
+
+// BenchmarkPutIndexUnbatched is the pre-chunk0-4 baseline: every
+// pieceCid<->cursor mapping and offset entry is written with its own
+// s.ds.Put call instead of a single batch, for comparison against
+// BenchmarkPutIndex. It still goes through updateMhBitmap per entry, exactly
+// like PutIndex does, so the two benchmarks differ only in batching and this
+// comparison actually isolates that.
+func BenchmarkPutIndexUnbatched(b *testing.B) {
+	ctx := context.Background()
+	idx := newBenchIndex(b).(*index.MultihashIndexSorted)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		store, err := NewLevelDBStore(ctx, LevelDBConfig{Path: b.TempDir()})
+		if err != nil {
+			b.Fatal(err)
+		}
+		pieceMh, err := multihash.Sum([]byte(fmt.Sprintf("piece-%d", i)), multihash.SHA2_256, -1)
+		if err != nil {
+			b.Fatal(err)
+		}
+		pieceCid := cid.NewCidV1(cid.Raw, pieceMh)
+		b.StartTimer()
+
+		if err := putIndexUnbatched(ctx, store, pieceCid, idx); err != nil {
+			b.Fatal(err)
+		}
+
+		b.StopTimer()
+		_ = store.Close(ctx)
+		b.StartTimer()
+	}
+}
+
+// putIndexUnbatched reimplements PutIndex's pre-chunk0-4 shape: every write
+// goes straight to s.ds instead of through a datastore.Batch. The mh2p/
+// bitmap update is left going through updateMhBitmap, same as PutIndex, so
+// this only varies the batching.
+func putIndexUnbatched(ctx context.Context, s *LevelDBStore, pieceCid cid.Cid, idx index.Index) error {
+	cursor, err := s.ReserveCursors(ctx, 1)
+	if err != nil {
+		return err
+	}
+	keyCursorPrefix := cursorPrefix(cursor)
+
+	cursorBuf := make([]byte, cursorSize)
+	binary.PutUvarint(cursorBuf, cursor)
+	if err := s.ds.Put(ctx, s.pieceCidToCursorKey(pieceCid), cursorBuf); err != nil {
+		return err
+	}
+
+	if err := s.ds.Put(ctx, s.cursorToPieceCidKey(cursor), []byte(pieceCid.String())); err != nil {
+		return err
+	}
+
+	iterable, ok := idx.(index.IterableIndex)
+	if !ok {
+		return fmt.Errorf("kvindex: wanted an IterableIndex")
+	}
+
+	return iterable.ForEach(func(m multihash.Multihash, offset uint64) error {
+		key := datastore.NewKey(fmt.Sprintf("%s%s", keyCursorPrefix, m.String()))
+
+		value := make([]byte, cursorSize)
+		binary.PutUvarint(value, offset)
+
+		if err := s.ds.Put(ctx, key, value); err != nil {
+			return err
+		}
+
+		return s.updateMhBitmap(ctx, m, func(bm *roaring.Bitmap) {
+			bm.Add(uint32(cursor))
+		})
+	})
+}
@@ -0,0 +1,56 @@
+package kvindex
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+)
+
+// TestGCPreservesLivePieces guards against GC mistaking a live piece's
+// cursor for an orphan: the pieceCid->cursor table must be scanned with a
+// prefix that actually matches the keys PutIndex writes there. It stores
+// several pieces, spanning the first few cursor values, since those are the
+// ones most likely to collide with any reserved key-layout prefix.
+func TestGCPreservesLivePieces(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := NewLevelDBStore(ctx, LevelDBConfig{Path: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = store.Close(ctx) }()
+
+	pieceCids := make([]cid.Cid, 3)
+	for i := range pieceCids {
+		pieceCids[i] = cid.NewCidV1(cid.Raw, mustMh(t, fmt.Sprintf("piece-%d", i)))
+
+		offsets := map[string]uint64{"a": 10, "b": 20}
+		if err := store.PutIndex(ctx, pieceCids[i], newTestIndex(t, offsets)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := store.GC(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	mhA := mustMh(t, "a")
+	for _, pieceCid := range pieceCids {
+		if has, err := store.HasPiece(ctx, pieceCid); err != nil || !has {
+			t.Fatalf("HasPiece(%v) after GC = %v, %v, want true, nil", pieceCid, has, err)
+		}
+		if offset, err := store.GetOffset(ctx, pieceCid, mhA); err != nil || offset != 10 {
+			t.Fatalf("GetOffset(%v, a) after GC = %d, %v, want 10, nil", pieceCid, offset, err)
+		}
+
+		records, err := store.GetRecords(ctx, pieceCid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 2 {
+			t.Fatalf("GetRecords(%v) after GC returned %d records, want 2", pieceCid, len(records))
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package kvindex
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipld/go-car/v2/index"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+)
+
+// MemoryStore is an in-memory Store, intended for tests and for callers that
+// don't need durability.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	pieces  map[cid.Cid]map[string]uint64 // pieceCid -> multihash string -> offset
+	reverse map[string]map[cid.Cid]bool   // multihash string -> set of pieceCids containing it
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		pieces:  make(map[cid.Cid]map[string]uint64),
+		reverse: make(map[string]map[cid.Cid]bool),
+	}
+}
+
+// PutIndex implements Store.
+func (s *MemoryStore) PutIndex(ctx context.Context, pieceCid cid.Cid, idx index.Index) error {
+	iterable, ok := idx.(index.IterableIndex)
+	if !ok {
+		return fmt.Errorf("kvindex: wanted %v but got %v", multicodec.CarMultihashIndexSorted, idx.Codec())
+	}
+
+	offsets := make(map[string]uint64)
+	err := iterable.ForEach(func(m multihash.Multihash, offset uint64) error {
+		offsets[string(m)] = offset
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if old, ok := s.pieces[pieceCid]; ok {
+		s.removeFromReverse(pieceCid, old)
+	}
+
+	s.pieces[pieceCid] = offsets
+	for mh := range offsets {
+		if s.reverse[mh] == nil {
+			s.reverse[mh] = make(map[cid.Cid]bool)
+		}
+		s.reverse[mh][pieceCid] = true
+	}
+
+	return nil
+}
+
+// removeFromReverse drops pieceCid from the reverse index of every
+// multihash in offsets, the set of offsets most recently stored for it.
+func (s *MemoryStore) removeFromReverse(pieceCid cid.Cid, offsets map[string]uint64) {
+	for mh := range offsets {
+		delete(s.reverse[mh], pieceCid)
+		if len(s.reverse[mh]) == 0 {
+			delete(s.reverse, mh)
+		}
+	}
+}
+
+// GetOffset implements Store.
+func (s *MemoryStore) GetOffset(ctx context.Context, pieceCid cid.Cid, mh multihash.Multihash) (uint64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	offsets, ok := s.pieces[pieceCid]
+	if !ok {
+		return 0, datastore.ErrNotFound
+	}
+
+	offset, ok := offsets[string(mh)]
+	if !ok {
+		return 0, datastore.ErrNotFound
+	}
+
+	return offset, nil
+}
+
+// GetRecords implements Store.
+func (s *MemoryStore) GetRecords(ctx context.Context, pieceCid cid.Cid) ([]index.Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	offsets, ok := s.pieces[pieceCid]
+	if !ok {
+		return nil, datastore.ErrNotFound
+	}
+
+	records := make([]index.Record, 0, len(offsets))
+	for mh, offset := range offsets {
+		records = append(records, index.Record{
+			Cid:    cid.NewCidV1(cid.Raw, multihash.Multihash(mh)),
+			Offset: offset,
+		})
+	}
+
+	return records, nil
+}
+
+// HasPiece implements Store.
+func (s *MemoryStore) HasPiece(ctx context.Context, pieceCid cid.Cid) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.pieces[pieceCid]
+	return ok, nil
+}
+
+// RemovePiece implements Store.
+func (s *MemoryStore) RemovePiece(ctx context.Context, pieceCid cid.Cid) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if offsets, ok := s.pieces[pieceCid]; ok {
+		s.removeFromReverse(pieceCid, offsets)
+	}
+	delete(s.pieces, pieceCid)
+	return nil
+}
+
+// PiecesContaining implements Store.
+func (s *MemoryStore) PiecesContaining(ctx context.Context, mh multihash.Multihash) ([]cid.Cid, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	pieces := s.reverse[string(mh)]
+	pieceCids := make([]cid.Cid, 0, len(pieces))
+	for pieceCid := range pieces {
+		pieceCids = append(pieceCids, pieceCid)
+	}
+
+	return pieceCids, nil
+}
+
+// Close implements Store.
+func (s *MemoryStore) Close(ctx context.Context) error {
+	return nil
+}
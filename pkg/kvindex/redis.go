@@ -0,0 +1,174 @@
+package kvindex
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car/v2/index"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisConfig configures the Redis-backed Store.
+type RedisConfig struct {
+	// Addr is the "host:port" of the Redis server.
+	Addr     string
+	Password string
+	DB       int
+}
+
+// RedisStore is a Redis-backed Store. Each piece is a hash, "piece:{cid}",
+// mapping a multihash's raw bytes to its fixed 8-byte big-endian offset, so
+// a lookup is a single O(1) HGET. A reverse set, "pieces:{mh}", tracks which
+// pieces contain a given multihash so PiecesContaining can answer without
+// scanning every piece's hash.
+type RedisStore struct {
+	client *redis.Client
+}
+
+var _ Store = (*RedisStore)(nil)
+
+// NewRedisStore connects to the Redis server described by cfg.
+func NewRedisStore(cfg RedisConfig) (*RedisStore, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     cfg.Addr,
+		Password: cfg.Password,
+		DB:       cfg.DB,
+	})
+
+	return &RedisStore{client: client}, nil
+}
+
+func pieceKey(pieceCid cid.Cid) string {
+	return fmt.Sprintf("piece:%s", pieceCid.String())
+}
+
+func reverseKey(mh multihash.Multihash) string {
+	return fmt.Sprintf("pieces:%s", mh.String())
+}
+
+func encodeOffset(offset uint64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, offset)
+	return buf
+}
+
+// PutIndex implements Store.
+//
+// If pieceCid already has a hash stored, PutIndex removes it first: HSet
+// only adds or overwrites the fields it's given, so without this any
+// multihash present in the old index but not the new one would keep a
+// stale hash field and a stale reverse-index entry in "pieces:{mh}".
+func (s *RedisStore) PutIndex(ctx context.Context, pieceCid cid.Cid, idx index.Index) error {
+	iterable, ok := idx.(index.IterableIndex)
+	if !ok {
+		return fmt.Errorf("kvindex: wanted %v but got %v", multicodec.CarMultihashIndexSorted, idx.Codec())
+	}
+
+	if has, err := s.HasPiece(ctx, pieceCid); err != nil {
+		return err
+	} else if has {
+		if err := s.RemovePiece(ctx, pieceCid); err != nil {
+			return err
+		}
+	}
+
+	pipe := s.client.Pipeline()
+
+	key := pieceKey(pieceCid)
+	err := iterable.ForEach(func(m multihash.Multihash, offset uint64) error {
+		pipe.HSet(ctx, key, []byte(m), encodeOffset(offset))
+		pipe.SAdd(ctx, reverseKey(m), pieceCid.String())
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// GetOffset implements Store.
+func (s *RedisStore) GetOffset(ctx context.Context, pieceCid cid.Cid, mh multihash.Multihash) (uint64, error) {
+	b, err := s.client.HGet(ctx, pieceKey(pieceCid), string([]byte(mh))).Bytes()
+	if err != nil {
+		return 0, err
+	}
+
+	return binary.BigEndian.Uint64(b), nil
+}
+
+// GetRecords implements Store.
+func (s *RedisStore) GetRecords(ctx context.Context, pieceCid cid.Cid) ([]index.Record, error) {
+	entries, err := s.client.HGetAll(ctx, pieceKey(pieceCid)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]index.Record, 0, len(entries))
+	for mh, offsetStr := range entries {
+		records = append(records, index.Record{
+			Cid:    cid.NewCidV1(cid.Raw, multihash.Multihash(mh)),
+			Offset: binary.BigEndian.Uint64([]byte(offsetStr)),
+		})
+	}
+
+	return records, nil
+}
+
+// HasPiece implements Store.
+func (s *RedisStore) HasPiece(ctx context.Context, pieceCid cid.Cid) (bool, error) {
+	n, err := s.client.Exists(ctx, pieceKey(pieceCid)).Result()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+// RemovePiece implements Store.
+func (s *RedisStore) RemovePiece(ctx context.Context, pieceCid cid.Cid) error {
+	key := pieceKey(pieceCid)
+
+	entries, err := s.client.HGetAll(ctx, key).Result()
+	if err != nil {
+		return err
+	}
+
+	pipe := s.client.Pipeline()
+	for mh := range entries {
+		pipe.SRem(ctx, reverseKey(multihash.Multihash(mh)), pieceCid.String())
+	}
+	pipe.Del(ctx, key)
+
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// PiecesContaining implements Store.
+func (s *RedisStore) PiecesContaining(ctx context.Context, mh multihash.Multihash) ([]cid.Cid, error) {
+	members, err := s.client.SMembers(ctx, reverseKey(mh)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	pieceCids := make([]cid.Cid, 0, len(members))
+	for _, m := range members {
+		pieceCid, err := cid.Parse(m)
+		if err != nil {
+			return nil, err
+		}
+		pieceCids = append(pieceCids, pieceCid)
+	}
+
+	return pieceCids, nil
+}
+
+// Close implements Store.
+func (s *RedisStore) Close(ctx context.Context) error {
+	return s.client.Close()
+}
@@ -0,0 +1,69 @@
+// Package kvindex defines the storage interface used to persist the mapping
+// from a piece CID's CAR index to the multihashes it contains, and ships the
+// backends that implement it.
+//
+// Callers such as markets/boost depend only on the Store interface, so the
+// backend can be swapped via Options without touching call sites.
+package kvindex
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car/v2/index"
+	"github.com/multiformats/go-multihash"
+)
+
+// Store persists CAR index records (multihash -> byte offset) grouped by
+// piece CID. Every method takes a context, including Close; the Close
+// implementations in this package do not observe it.
+type Store interface {
+	// PutIndex records every entry of idx under pieceCid.
+	PutIndex(ctx context.Context, pieceCid cid.Cid, idx index.Index) error
+	// GetOffset returns the byte offset of mh within pieceCid's CAR file.
+	GetOffset(ctx context.Context, pieceCid cid.Cid, mh multihash.Multihash) (uint64, error)
+	// GetRecords returns every (multihash, offset) pair stored for pieceCid.
+	GetRecords(ctx context.Context, pieceCid cid.Cid) ([]index.Record, error)
+	// HasPiece reports whether pieceCid has an index stored.
+	HasPiece(ctx context.Context, pieceCid cid.Cid) (bool, error)
+	// RemovePiece deletes every entry stored for pieceCid.
+	RemovePiece(ctx context.Context, pieceCid cid.Cid) error
+	// PiecesContaining returns every pieceCid whose index contains mh.
+	PiecesContaining(ctx context.Context, mh multihash.Multihash) ([]cid.Cid, error)
+	// Close releases any resources held by the store.
+	Close(ctx context.Context) error
+}
+
+// Kind selects which Store implementation Open constructs.
+type Kind string
+
+const (
+	KindLevelDB Kind = "leveldb"
+	KindMemory  Kind = "memory"
+	KindRedis   Kind = "redis"
+)
+
+// Options selects a backend Kind and carries that backend's settings, so a
+// service can switch backends by editing config rather than recompiling
+// call sites.
+type Options struct {
+	Kind Kind
+
+	LevelDB LevelDBConfig
+	Redis   RedisConfig
+}
+
+// Open constructs the Store selected by cfg.Kind.
+func Open(ctx context.Context, cfg Options) (Store, error) {
+	switch cfg.Kind {
+	case KindLevelDB:
+		return NewLevelDBStore(ctx, cfg.LevelDB)
+	case KindMemory:
+		return NewMemoryStore(), nil
+	case KindRedis:
+		return NewRedisStore(cfg.Redis)
+	default:
+		return nil, fmt.Errorf("kvindex: unknown backend kind %q", cfg.Kind)
+	}
+}
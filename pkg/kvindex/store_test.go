@@ -0,0 +1,195 @@
+package kvindex
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car/v2/index"
+	"github.com/multiformats/go-multihash"
+)
+
+// newTestIndex builds an index.Index over the given multihash/offset pairs,
+// suitable for PutIndex.
+func newTestIndex(t *testing.T, offsets map[string]uint64) index.Index {
+	t.Helper()
+
+	records := make([]index.Record, 0, len(offsets))
+	for s, offset := range offsets {
+		mh, err := multihash.Sum([]byte(s), multihash.SHA2_256, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, index.Record{
+			Cid:    cid.NewCidV1(cid.Raw, mh),
+			Offset: offset,
+		})
+	}
+
+	idx := index.NewMultihashSorted()
+	if err := idx.Load(records); err != nil {
+		t.Fatal(err)
+	}
+
+	return idx
+}
+
+func mustMh(t *testing.T, s string) multihash.Multihash {
+	t.Helper()
+
+	mh, err := multihash.Sum([]byte(s), multihash.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mh
+}
+
+// TestStoreRemoveRestore checks that removing a pieceCid leaves no stale
+// offsets behind, and that re-storing the same pieceCid afterwards reads
+// back correctly, across the memory and LevelDB Store backends.
+func TestStoreRemoveRestore(t *testing.T) {
+	ctx := context.Background()
+
+	backends := map[string]func(t *testing.T) Store{
+		"memory": func(t *testing.T) Store {
+			return NewMemoryStore()
+		},
+		"leveldb": func(t *testing.T) Store {
+			s, err := NewLevelDBStore(ctx, LevelDBConfig{Path: t.TempDir()})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return s
+		},
+	}
+
+	for name, newStore := range backends {
+		t.Run(name, func(t *testing.T) {
+			store := newStore(t)
+			defer func() { _ = store.Close(ctx) }()
+
+			pieceCid, err := cid.Parse("bafkqaaa")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			first := map[string]uint64{"a": 10, "b": 20}
+			if err := store.PutIndex(ctx, pieceCid, newTestIndex(t, first)); err != nil {
+				t.Fatal(err)
+			}
+
+			mhA, mhB := mustMh(t, "a"), mustMh(t, "b")
+
+			if offset, err := store.GetOffset(ctx, pieceCid, mhA); err != nil || offset != 10 {
+				t.Fatalf("GetOffset(a) = %d, %v, want 10, nil", offset, err)
+			}
+
+			if err := store.RemovePiece(ctx, pieceCid); err != nil {
+				t.Fatal(err)
+			}
+
+			if has, err := store.HasPiece(ctx, pieceCid); err != nil || has {
+				t.Fatalf("HasPiece after RemovePiece = %v, %v, want false, nil", has, err)
+			}
+			if _, err := store.GetOffset(ctx, pieceCid, mhA); err == nil {
+				t.Fatal("GetOffset(a) after RemovePiece succeeded, want error")
+			}
+			if records, err := store.GetRecords(ctx, pieceCid); err == nil && len(records) != 0 {
+				t.Fatalf("GetRecords after RemovePiece = %v, want none", records)
+			}
+			if pieces, err := store.PiecesContaining(ctx, mhA); err != nil || len(pieces) != 0 {
+				t.Fatalf("PiecesContaining(a) after RemovePiece = %v, %v, want none", pieces, err)
+			}
+
+			second := map[string]uint64{"a": 30, "c": 40}
+			if err := store.PutIndex(ctx, pieceCid, newTestIndex(t, second)); err != nil {
+				t.Fatal(err)
+			}
+
+			if offset, err := store.GetOffset(ctx, pieceCid, mhA); err != nil || offset != 30 {
+				t.Fatalf("GetOffset(a) after re-store = %d, %v, want 30, nil", offset, err)
+			}
+			if _, err := store.GetOffset(ctx, pieceCid, mhB); err == nil {
+				t.Fatal("GetOffset(b) after re-store succeeded, want error: b is not part of the new index")
+			}
+
+			records, err := store.GetRecords(ctx, pieceCid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(records) != len(second) {
+				t.Fatalf("GetRecords after re-store returned %d records, want %d", len(records), len(second))
+			}
+
+			pieces, err := store.PiecesContaining(ctx, mhA)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(pieces) != 1 || pieces[0] != pieceCid {
+				t.Fatalf("PiecesContaining(a) after re-store = %v, want [%v]", pieces, pieceCid)
+			}
+		})
+	}
+}
+
+// TestPutIndexOverwrite checks that PutIndex-ing a pieceCid that already has
+// an index replaces it in place, without an explicit RemovePiece in between:
+// no stale offsets from the old index should remain, and no multihash that
+// was only in the old index should still resolve back to the piece via
+// PiecesContaining.
+func TestPutIndexOverwrite(t *testing.T) {
+	ctx := context.Background()
+
+	backends := map[string]func(t *testing.T) Store{
+		"memory": func(t *testing.T) Store {
+			return NewMemoryStore()
+		},
+		"leveldb": func(t *testing.T) Store {
+			s, err := NewLevelDBStore(ctx, LevelDBConfig{Path: t.TempDir()})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return s
+		},
+	}
+
+	for name, newStore := range backends {
+		t.Run(name, func(t *testing.T) {
+			store := newStore(t)
+			defer func() { _ = store.Close(ctx) }()
+
+			pieceCid, err := cid.Parse("bafkqaaa")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := store.PutIndex(ctx, pieceCid, newTestIndex(t, map[string]uint64{"a": 10, "b": 20})); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.PutIndex(ctx, pieceCid, newTestIndex(t, map[string]uint64{"a": 99})); err != nil {
+				t.Fatal(err)
+			}
+
+			mhA, mhB := mustMh(t, "a"), mustMh(t, "b")
+
+			if offset, err := store.GetOffset(ctx, pieceCid, mhA); err != nil || offset != 99 {
+				t.Fatalf("GetOffset(a) after overwrite = %d, %v, want 99, nil", offset, err)
+			}
+			if _, err := store.GetOffset(ctx, pieceCid, mhB); err == nil {
+				t.Fatal("GetOffset(b) after overwrite succeeded, want error: b is not part of the new index")
+			}
+
+			records, err := store.GetRecords(ctx, pieceCid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(records) != 1 {
+				t.Fatalf("GetRecords after overwrite returned %d records, want 1", len(records))
+			}
+
+			if pieces, err := store.PiecesContaining(ctx, mhB); err != nil || len(pieces) != 0 {
+				t.Fatalf("PiecesContaining(b) after overwrite = %v, %v, want none: b is stale", pieces, err)
+			}
+		})
+	}
+}